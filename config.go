@@ -0,0 +1,144 @@
+package s3c4
+
+import (
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Config carries the connection details needed to build an S3-compatible
+// client for NewWithConfig.
+type Config struct {
+	// Endpoint overrides the default AWS endpoint, for S3-compatible
+	// backends such as Minio or DigitalOcean Spaces. Leave empty for AWS S3.
+	Endpoint string
+
+	// Region is the AWS region, or the region-like value required by some
+	// S3-compatible backends.
+	Region string
+
+	// AccessKey, SecretKey, and SessionToken are static credentials. If both
+	// AccessKey and SecretKey are empty, and Profile is also empty, the
+	// default credential chain is used: AWS_* environment variables, the
+	// shared credentials file, and EC2/ECS/EKS instance role credentials,
+	// in that order.
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+
+	// Profile selects a named profile from the shared credentials file when
+	// AccessKey/SecretKey are empty.
+	Profile string
+
+	// UsePathStyle forces path-style addressing (bucket in the path rather
+	// than the host), required by most S3-compatible backends.
+	UsePathStyle bool
+
+	// DisableSSL disables HTTPS, useful for local test backends.
+	DisableSSL bool
+
+	// HTTPClient, if set, is used instead of the SDK's default client.
+	HTTPClient *http.Client
+}
+
+// NewWithConfig builds the S3 client described by cfg and returns a Store
+// backed by it, equivalent to calling New with a hand-assembled
+// s3iface.S3API but without requiring the caller to build the AWS session
+// themselves.
+func NewWithConfig(cfg Config, bucket, keyprefix string) (*Store, error) {
+	awsCfg := aws.NewConfig()
+
+	if len(cfg.Region) > 0 {
+		awsCfg = awsCfg.WithRegion(cfg.Region)
+	}
+	if len(cfg.Endpoint) > 0 {
+		awsCfg = awsCfg.WithEndpoint(cfg.Endpoint)
+	}
+	if cfg.UsePathStyle {
+		awsCfg = awsCfg.WithS3ForcePathStyle(true)
+	}
+	if cfg.DisableSSL {
+		awsCfg = awsCfg.WithDisableSSL(true)
+	}
+	if cfg.HTTPClient != nil {
+		awsCfg = awsCfg.WithHTTPClient(cfg.HTTPClient)
+	}
+
+	switch {
+	case len(cfg.AccessKey) > 0 || len(cfg.SecretKey) > 0:
+		awsCfg = awsCfg.WithCredentials(credentials.NewStaticCredentials(cfg.AccessKey, cfg.SecretKey, cfg.SessionToken))
+	case len(cfg.Profile) > 0:
+		awsCfg = awsCfg.WithCredentials(credentials.NewSharedCredentials("", cfg.Profile))
+	}
+	// Otherwise leave Credentials unset so the session falls back to the
+	// default credential chain.
+
+	ses, err := session.NewSession(awsCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(s3.New(ses), bucket, keyprefix)
+}
+
+// MinioConfig returns a Config preset for a self-hosted Minio server at
+// `endpoint` (e.g. "localhost:9000"). Minio has no real notion of region,
+// but the SDK requires one be set, so "us-east-1" is used.
+func MinioConfig(endpoint, accessKey, secretKey string) Config {
+	return Config{
+		Endpoint:     endpoint,
+		Region:       "us-east-1",
+		AccessKey:    accessKey,
+		SecretKey:    secretKey,
+		UsePathStyle: true,
+	}
+}
+
+// DigitalOceanSpacesConfig returns a Config preset for a DigitalOcean Spaces
+// region, e.g. region "nyc3" talks to "nyc3.digitaloceanspaces.com".
+func DigitalOceanSpacesConfig(region, accessKey, secretKey string) Config {
+	return Config{
+		Endpoint:  region + ".digitaloceanspaces.com",
+		Region:    region,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+	}
+}
+
+// WasabiConfig returns a Config preset for Wasabi, e.g. region "us-east-1"
+// talks to "s3.us-east-1.wasabisys.com".
+func WasabiConfig(region, accessKey, secretKey string) Config {
+	return Config{
+		Endpoint:  "s3." + region + ".wasabisys.com",
+		Region:    region,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+	}
+}
+
+// B2Config returns a Config preset for Backblaze B2's S3-compatible API,
+// e.g. region "us-west-002" talks to "s3.us-west-002.backblazeb2.com".
+func B2Config(region, accessKey, secretKey string) Config {
+	return Config{
+		Endpoint:  "s3." + region + ".backblazeb2.com",
+		Region:    region,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+	}
+}
+
+// GCSConfig returns a Config preset for Google Cloud Storage's S3-compatible
+// XML interoperability endpoint. GCS HMAC keys are used as AccessKey and
+// SecretKey.
+func GCSConfig(accessKey, secretKey string) Config {
+	return Config{
+		Endpoint:     "storage.googleapis.com",
+		Region:       "auto",
+		AccessKey:    accessKey,
+		SecretKey:    secretKey,
+		UsePathStyle: true,
+	}
+}
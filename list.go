@@ -0,0 +1,162 @@
+package s3c4
+
+import (
+	"context"
+	"fmt"
+	filepath "path"
+	"strings"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// List returns a channel of every c4.ID stored under `prefix` (relative to
+// the Store's own key prefix), and a channel that receives at most one error
+// if the listing fails. Keys that don't parse as a c4.ID are skipped. Both
+// channels are closed when the listing is done.
+func (s *Store) List(ctx context.Context, prefix string) (<-chan c4.ID, <-chan error) {
+	ids := make(chan c4.ID)
+	errc := make(chan error, 1)
+
+	listPrefix := prefix
+	if len(s.prefix) > 0 {
+		listPrefix = filepath.Join(s.prefix, prefix)
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(ids)
+		defer close(errc)
+
+		err := s.s3.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+			Bucket: aws.String(s.bucket),
+			Prefix: aws.String(listPrefix),
+		}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, obj := range page.Contents {
+				key := *obj.Key
+				if len(s.prefix) > 0 {
+					key = strings.TrimPrefix(key, s.prefix+"/")
+				}
+				id, err := c4.Parse(key)
+				if err != nil {
+					continue
+				}
+				select {
+				case ids <- id:
+				case <-ctx.Done():
+					return false
+				}
+			}
+			return true
+		})
+		if err != nil {
+			errc <- err
+		}
+	}()
+
+	return ids, errc
+}
+
+// Walk calls fn for every c4.ID stored under `prefix`, stopping and
+// returning the first error fn returns. It returns any error encountered
+// while listing.
+func (s *Store) Walk(ctx context.Context, prefix string, fn func(c4.ID) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ids, errc := s.List(ctx, prefix)
+	for id := range ids {
+		if err := fn(id); err != nil {
+			return err
+		}
+	}
+	return <-errc
+}
+
+// ErrRemoveAll is returned by RemoveAll when one or more objects failed to
+// delete, keyed by the c4.ID that failed.
+type ErrRemoveAll struct {
+	Failures map[c4.ID]error
+}
+
+func (e ErrRemoveAll) Error() string {
+	return fmt.Sprintf("s3c4: failed to remove %d of the requested objects", len(e.Failures))
+}
+
+// RemoveAll deletes every object in `ids`, batching into DeleteObjects calls
+// of up to 1000 keys. It returns ErrRemoveAll listing any per-key failures.
+func (s *Store) RemoveAll(ctx context.Context, ids []c4.ID) error {
+	failures := make(map[c4.ID]error)
+
+	const batchSize = 1000
+	for i := 0; i < len(ids); i += batchSize {
+		end := i + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		batch := ids[i:end]
+
+		objects := make([]*s3.ObjectIdentifier, len(batch))
+		keyToID := make(map[string]c4.ID, len(batch))
+		for j, id := range batch {
+			key := s.objectKey(id)
+			objects[j] = &s3.ObjectIdentifier{Key: aws.String(key)}
+			keyToID[key] = id
+		}
+
+		resp, err := s.s3.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(s.bucket),
+			Delete: &s3.Delete{
+				Objects: objects,
+				Quiet:   aws.Bool(true),
+			},
+		})
+		if err != nil {
+			for _, id := range batch {
+				failures[id] = err
+			}
+			continue
+		}
+
+		for _, e := range resp.Errors {
+			if id, ok := keyToID[*e.Key]; ok {
+				failures[id] = fmt.Errorf("%s: %s", *e.Code, *e.Message)
+			}
+		}
+	}
+
+	if len(failures) > 0 {
+		return ErrRemoveAll{Failures: failures}
+	}
+	return nil
+}
+
+// Exists reports whether an object for `id` is present in the store. It is
+// equivalent to ExistsContext(context.Background(), id).
+func (s *Store) Exists(id c4.ID) (bool, error) {
+	return s.ExistsContext(context.Background(), id)
+}
+
+// ExistsContext is the context-aware variant of Exists.
+func (s *Store) ExistsContext(ctx context.Context, id c4.ID) (bool, error) {
+	headInput := &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(id)),
+	}
+	headInput.SSECustomerAlgorithm, headInput.SSECustomerKey, headInput.SSECustomerKeyMD5 = s.sseCustomerFields()
+
+	_, err := s.s3.HeadObjectWithContext(ctx, headInput)
+	if err == nil {
+		return true, nil
+	}
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey, "NotFound":
+			return false, nil
+		}
+	}
+	return false, err
+}
@@ -0,0 +1,98 @@
+package s3c4
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// TestListStripsPrefixAndSkipsInvalidKeys drives List against a mocked
+// ListObjectsV2Pages response to confirm it joins the Store's key prefix
+// into the request, strips it back off each returned key, and silently
+// skips keys that don't parse as a c4.ID.
+func TestListStripsPrefixAndSkipsInvalidKeys(t *testing.T) {
+	id1 := c4.Identify(bytes.NewReader([]byte("one")))
+	id2 := c4.Identify(bytes.NewReader([]byte("two")))
+
+	mock := &mockS3{
+		listObjectsV2PagesWithContext: func(in *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+			if *in.Prefix != "store/sub" {
+				t.Fatalf("Prefix = %q, want %q", *in.Prefix, "store/sub")
+			}
+			page := &s3.ListObjectsV2Output{
+				Contents: []*s3.Object{
+					{Key: aws.String("store/" + id1.String())},
+					{Key: aws.String("store/not-a-c4-id")},
+					{Key: aws.String("store/" + id2.String())},
+				},
+			}
+			fn(page, true)
+			return nil
+		},
+	}
+
+	store, err := New(mock, "bucket", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids, errc := store.List(context.Background(), "sub")
+
+	got := make(map[c4.ID]bool)
+	for id := range ids {
+		got[id] = true
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("List error: %v", err)
+	}
+
+	if len(got) != 2 || !got[id1] || !got[id2] {
+		t.Fatalf("List ids = %v, want {%s, %s}", got, id1, id2)
+	}
+}
+
+// TestRemoveAllMapsPerKeyErrors drives RemoveAll against a mocked
+// DeleteObjects response to confirm a per-key error in the quiet-mode
+// response is mapped back to the failing c4.ID, and ids that succeeded
+// aren't reported as failures.
+func TestRemoveAllMapsPerKeyErrors(t *testing.T) {
+	id1 := c4.Identify(bytes.NewReader([]byte("one")))
+	id2 := c4.Identify(bytes.NewReader([]byte("two")))
+
+	mock := &mockS3{
+		deleteObjectsWithContext: func(in *s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error) {
+			if len(in.Delete.Objects) != 2 {
+				t.Fatalf("expected 2 objects in DeleteObjects request, got %d", len(in.Delete.Objects))
+			}
+			return &s3.DeleteObjectsOutput{
+				Errors: []*s3.Error{
+					{Key: aws.String(id2.String()), Code: aws.String("AccessDenied"), Message: aws.String("denied")},
+				},
+			}, nil
+		},
+	}
+
+	store, err := New(mock, "bucket", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.RemoveAll(context.Background(), []c4.ID{id1, id2})
+	rmErr, ok := err.(ErrRemoveAll)
+	if !ok {
+		t.Fatalf("expected ErrRemoveAll, got %v (%T)", err, err)
+	}
+	if len(rmErr.Failures) != 1 {
+		t.Fatalf("Failures = %v, want 1 entry", rmErr.Failures)
+	}
+	if _, ok := rmErr.Failures[id2]; !ok {
+		t.Fatalf("expected failure for id2 %s, got %v", id2, rmErr.Failures)
+	}
+	if _, ok := rmErr.Failures[id1]; ok {
+		t.Fatalf("did not expect failure for id1 %s", id1)
+	}
+}
@@ -0,0 +1,146 @@
+package s3c4
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// Open returns a ReadCloser that streams the object for `id` from S3 without
+// buffering the whole object into memory; it is fetched in ranged parts as
+// described for OpenRange. It is equivalent to OpenContext(context.Background(), id).
+func (s *Store) Open(id c4.ID) (io.ReadCloser, error) {
+	return s.OpenContext(context.Background(), id)
+}
+
+// OpenContext is the context-aware variant of Open.
+func (s *Store) OpenContext(ctx context.Context, id c4.ID) (io.ReadCloser, error) {
+	return s.openRange(ctx, id, 0, -1)
+}
+
+// OpenRange returns a ReadCloser that streams `length` bytes of the object
+// for `id` starting at `offset`. A negative length reads to the end of the
+// object. The object is fetched as a pool of ranged GetObject requests,
+// sized by DownloadPartSize and run DownloadConcurrency at a time, and
+// written to the returned reader in offset order as each part completes.
+func (s *Store) OpenRange(id c4.ID, offset, length int64) (io.ReadCloser, error) {
+	return s.openRange(context.Background(), id, offset, length)
+}
+
+func (s *Store) openRange(ctx context.Context, id c4.ID, offset, length int64) (io.ReadCloser, error) {
+	key := s.objectKey(id)
+
+	headInput := &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+	headInput.SSECustomerAlgorithm, headInput.SSECustomerKey, headInput.SSECustomerKeyMD5 = s.sseCustomerFields()
+
+	head, err := s.s3.HeadObjectWithContext(ctx, headInput)
+	if err != nil {
+		return nil, err
+	}
+
+	size := *head.ContentLength
+	if offset < 0 || offset > size {
+		return nil, fmt.Errorf("s3c4: offset %d out of range for %s (%d bytes)", offset, key, size)
+	}
+	end := size
+	if length >= 0 && offset+length < end {
+		end = offset + length
+	}
+
+	partSize := s.DownloadPartSize
+	if partSize <= 0 {
+		partSize = s3manager.DefaultDownloadPartSize
+	}
+	concurrency := s.DownloadConcurrency
+	if concurrency <= 0 {
+		concurrency = s3manager.DefaultDownloadConcurrency
+	}
+
+	total := end - offset
+	if total <= 0 {
+		// offset==end, e.g. a zero-length object: there are no bytes to
+		// range over, and a "bytes=0-(-1)" Range header would be rejected
+		// by S3, so skip the GetObject pool entirely.
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+	nparts := (total + partSize - 1) / partSize
+
+	type part struct {
+		data []byte
+		err  error
+	}
+	results := make([]chan part, nparts)
+	for i := range results {
+		results[i] = make(chan part, 1)
+	}
+
+	fetch := func(i int64) {
+		start := offset + i*partSize
+		stop := start + partSize
+		if stop > end {
+			stop = end
+		}
+
+		getInput := &s3.GetObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(key),
+			Range:  aws.String(fmt.Sprintf("bytes=%d-%d", start, stop-1)),
+		}
+		getInput.SSECustomerAlgorithm, getInput.SSECustomerKey, getInput.SSECustomerKeyMD5 = s.sseCustomerFields()
+
+		buff := &aws.WriteAtBuffer{}
+		_, err := s.downloader.DownloadWithContext(ctx, buff, getInput)
+		results[i] <- part{data: buff.Bytes(), err: err}
+	}
+
+	r, w := io.Pipe()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		// Launch and drain in a sliding window of `concurrency` parts, so at
+		// most `concurrency` downloads are ever outstanding or buffered
+		// ahead of the one currently being written to the pipe. Launching
+		// every part up front (throttled only by a semaphore) lets nearly
+		// the whole object finish and sit buffered in memory before the
+		// first byte reaches the caller; this keeps peak memory at
+		// O(partSize * concurrency) instead of O(objectSize).
+		next := int64(0)
+		for ; next < nparts && next < int64(concurrency); next++ {
+			go fetch(next)
+		}
+
+		for i := int64(0); i < nparts; i++ {
+			p := <-results[i]
+			if p.err != nil {
+				w.CloseWithError(p.err)
+				return
+			}
+			if next < nparts {
+				go fetch(next)
+				next++
+			}
+			if _, err := w.Write(p.data); err != nil {
+				return
+			}
+		}
+		w.Close()
+	}()
+
+	// Returning r directly (rather than wrapping it, e.g. with
+	// ioutil.NopCloser) matters: closing an *io.PipeReader early makes the
+	// producer goroutine's pending w.Write unblock with io.ErrClosedPipe, so
+	// it returns and its deferred s.wg.Done() runs instead of leaking.
+	return r, nil
+}
@@ -1,10 +1,9 @@
 package s3c4
 
 import (
-	"bytes"
+	"context"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	filepath "path"
 	"sync"
@@ -34,8 +33,73 @@ type Store struct {
 	// The default is 500 milliseconds
 	ConfirmationRequestRate time.Duration
 
+	// VerifyOnWrite indicates whether the bytes written by Create should be
+	// hashed as they stream and compared against the target c4.ID on Close,
+	// deleting the object and returning ErrIDMismatch if they don't match.
+	// The default is `true`, set to `false` for trusted pipelines that can't
+	// afford the extra hashing pass.
+	VerifyOnWrite bool
+
+	// PartSize is the size in bytes of each part of a multipart upload.
+	// The default is the s3manager default (5MiB) if left at zero.
+	PartSize int64
+
+	// Concurrency is the number of parts uploaded in parallel during a
+	// multipart upload. The default is the s3manager default if left at zero.
+	Concurrency int
+
+	// LeavePartsOnError indicates whether failed multipart uploads should be
+	// left on S3 instead of being aborted, so they can be inspected or
+	// resumed by hand. The default is `false`.
+	LeavePartsOnError bool
+
+	// ResumeDir, if set, enables resumable multipart uploads. Create writes a
+	// journal file to this directory, keyed by c4.ID, recording the
+	// multipart UploadId and completed parts as they succeed. Use
+	// ResumeCreate to continue an upload after a process restart, and
+	// AbortCreate to give up on one. The writers for these uploads finish
+	// with CompleteMultipartUpload on Close and issue no HeadObject
+	// confirmation, so ConfirmCreate does not apply to them.
+	ResumeDir string
+
+	// DownloadPartSize is the size in bytes of each ranged GetObject request
+	// issued by Open/OpenRange. The default is the s3manager default
+	// (5MiB) if left at zero.
+	DownloadPartSize int64
+
+	// DownloadConcurrency is the number of ranged GetObject requests made in
+	// parallel by Open/OpenRange. The default is the s3manager default if
+	// left at zero.
+	DownloadConcurrency int
+
+	// SSEAlgorithm selects server-side encryption for objects written by
+	// Create, either "AES256" or "aws:kms". Leave empty to disable.
+	SSEAlgorithm string
+
+	// SSEKMSKeyID is the KMS key id or ARN to use when SSEAlgorithm is
+	// "aws:kms". Leave empty to use the account's default KMS key.
+	SSEKMSKeyID string
+
+	// SSECustomerAlgorithm, SSECustomerKey, and SSECustomerKeyMD5 configure
+	// SSE-C. The same customer key must be supplied on every subsequent
+	// Open/HeadObject call for the object, so these are applied to reads as
+	// well as writes.
+	SSECustomerAlgorithm string
+	SSECustomerKey       string
+	SSECustomerKeyMD5    string
+
+	// StorageClass sets the S3 storage class for objects written by Create,
+	// e.g. "STANDARD_IA" or "GLACIER". Leave empty for the bucket default.
+	StorageClass string
+
+	// ACL sets a canned ACL for objects written by Create. Leave empty for
+	// the bucket default.
+	ACL string
+
+	// Metadata is attached as user metadata to objects written by Create.
+	Metadata map[string]string
+
 	s3         s3iface.S3API
-	uploader   *s3manager.Uploader
 	downloader *s3manager.Downloader
 	bucket     string
 	prefix     string
@@ -48,14 +112,16 @@ type Store struct {
 // provided then key will only be the c4 id.
 func New(s3api s3iface.S3API, bucket string, keyprefix string) (*Store, error) {
 
-	// Create an uploader with S3 client and default options
+	// Create a downloader with S3 client and default options. Uploaders are
+	// built per-call in CreateContext since their options vary per Store
+	// field lookup and must not be shared across concurrent uploads.
 	s := &Store{
 		ConfirmCreate:           true,
 		ConfirmationTimeout:     time.Second * 5,
 		ConfirmationRequestRate: time.Millisecond * 500,
+		VerifyOnWrite:           true,
 
 		s3:         s3api,
-		uploader:   s3manager.NewUploaderWithClient(s3api),
 		downloader: s3manager.NewDownloaderWithClient(s3api),
 		bucket:     bucket,
 		prefix:     keyprefix,
@@ -65,56 +131,56 @@ func New(s3api s3iface.S3API, bucket string, keyprefix string) (*Store, error) {
 	return s, nil
 }
 
-func (s *Store) Open(id c4.ID) (io.ReadCloser, error) {
+// objectKey returns the S3 key that `id` is stored under, including prefix.
+func (s *Store) objectKey(id c4.ID) string {
 	key := id.String()
 	if len(s.prefix) > 0 {
 		key = filepath.Join(s.prefix, key)
 	}
+	return key
+}
 
-	// Perform an upload.
-	buff := &aws.WriteAtBuffer{}
-	n, err := s.downloader.Download(buff, &s3.GetObjectInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(key),
-	})
-	if err != nil {
-		return nil, fmt.Errorf("%s %s (n == %d)", key, err, n)
+// sseCustomerFields returns the SSE-C parameters to attach to a request, or
+// three nils if SSE-C is not configured. The same values must be supplied on
+// both the write and every subsequent read of the object.
+func (s *Store) sseCustomerFields() (algorithm, key, keyMD5 *string) {
+	if len(s.SSECustomerAlgorithm) == 0 {
+		return nil, nil, nil
 	}
+	return aws.String(s.SSECustomerAlgorithm), aws.String(s.SSECustomerKey), aws.String(s.SSECustomerKeyMD5)
+}
 
-	r, w := io.Pipe()
-
-	s.wg.Add(1)
-	go func() {
-		defer func() {
-			err := w.Close()
-			if err != nil {
-				r.CloseWithError(err)
-			}
-			s.wg.Done()
-		}()
-
-		_, err = io.Copy(w, bytes.NewReader(buff.Bytes()))
-		if err != nil {
-			r.CloseWithError(err)
-			return
-		}
-	}()
-
-	return ioutil.NopCloser(r), nil
+// metadata returns s.Metadata as an aws.StringMap, or nil if unset.
+func (s *Store) metadata() map[string]*string {
+	if len(s.Metadata) == 0 {
+		return nil
+	}
+	return aws.StringMap(s.Metadata)
 }
 
 type s3WriteCloser struct {
+	ctx context.Context
+
 	confirmCreate           bool
 	confirmationTimeout     time.Duration
 	confirmationRequestRate time.Duration
 
 	id c4.ID
 
-	s3 s3iface.S3API
+	s3     s3iface.S3API
+	bucket string
+	key    string
 
 	// write pipe
 	w io.WriteCloser
 
+	// verify is true when VerifyOnWrite is set, in which case every byte
+	// written is tee'd into hw so the c4 id of the uploaded bytes can be
+	// compared against id once the upload is complete.
+	verify bool
+	hw     io.WriteCloser
+	idc    chan c4.ID
+
 	closed bool
 
 	// prepared HeadObjectInput for HEAD requests
@@ -122,7 +188,13 @@ type s3WriteCloser struct {
 }
 
 func (w *s3WriteCloser) Write(b []byte) (int, error) {
-	return w.w.Write(b)
+	n, err := w.w.Write(b)
+	if n > 0 && w.verify {
+		if _, herr := w.hw.Write(b[:n]); herr != nil {
+			return n, herr
+		}
+	}
+	return n, err
 }
 
 // ErrConfirmationTimeout is the error type returned on Close if object creation
@@ -137,6 +209,19 @@ func (e ErrConfirmationTimeout) Error() string {
 		e.ConfirmationTimeout, e.Id)
 }
 
+// ErrIDMismatch is returned by Close when the bytes written do not hash to
+// the c4.ID given to Store.Create. The object just uploaded to that id's key
+// is deleted before the error is returned, so a key never holds data that
+// doesn't hash to itself.
+type ErrIDMismatch struct {
+	Expected c4.ID
+	Actual   c4.ID
+}
+
+func (e ErrIDMismatch) Error() string {
+	return fmt.Sprintf("c4 id mismatch: expected %s, got %s", e.Expected, e.Actual)
+}
+
 func (w *s3WriteCloser) Close() error {
 	if w.closed {
 		return fmt.Errorf("already closed")
@@ -147,6 +232,23 @@ func (w *s3WriteCloser) Close() error {
 		return err
 	}
 
+	if w.verify {
+		if err := w.hw.Close(); err != nil {
+			return err
+		}
+		actual := <-w.idc
+		if actual != w.id {
+			_, err := w.s3.DeleteObjectWithContext(w.ctx, &s3.DeleteObjectInput{
+				Bucket: aws.String(w.bucket),
+				Key:    aws.String(w.key),
+			})
+			if err != nil {
+				return err
+			}
+			return ErrIDMismatch{Expected: w.id, Actual: actual}
+		}
+	}
+
 	if !w.confirmCreate {
 		return nil
 	}
@@ -158,19 +260,23 @@ func (w *s3WriteCloser) Close() error {
 	go func() {
 		defer close(headok)
 		for {
-			_, err := w.s3.HeadObject(w.headInput)
+			_, err := w.s3.HeadObjectWithContext(w.ctx, w.headInput)
 			if err == nil {
 				return
 			}
 			select {
 			case <-done:
 				return
+			case <-w.ctx.Done():
+				return
 			case <-time.After(w.confirmationRequestRate):
 			}
 		}
 	}()
 
 	select {
+	case <-w.ctx.Done():
+		return w.ctx.Err()
 	case <-time.After(w.confirmationTimeout):
 		return ErrConfirmationTimeout{w.id, w.confirmationTimeout}
 	case <-headok:
@@ -179,8 +285,21 @@ func (w *s3WriteCloser) Close() error {
 }
 
 // Create returns a io.WriteCloser that blocks when Closed until the object
-// header can be read.
+// header can be read. It is equivalent to CreateContext(context.Background(), id).
 func (s *Store) Create(id c4.ID) (io.WriteCloser, error) {
+	return s.CreateContext(context.Background(), id)
+}
+
+// CreateContext is the context-aware variant of Create. Canceling ctx aborts
+// the in-flight upload and, if ConfirmCreate is set, the HeadObject
+// confirmation loop in the returned WriteCloser's Close.
+//
+// When VerifyOnWrite is set, the c4 id of the bytes written is computed as
+// they stream through Write, and on Close is compared against `id`. If they
+// don't match, Close returns ErrIDMismatch after deleting the uploaded object
+// (or, with ResumeDir set, after aborting the multipart upload unless
+// LeavePartsOnError is set).
+func (s *Store) CreateContext(ctx context.Context, id c4.ID) (io.WriteCloser, error) {
 	key := id.String()
 	if len(s.prefix) > 0 {
 		key = filepath.Join(s.prefix, key)
@@ -190,29 +309,78 @@ func (s *Store) Create(id c4.ID) (io.WriteCloser, error) {
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(key),
 	}
+	headInput.SSECustomerAlgorithm, headInput.SSECustomerKey, headInput.SSECustomerKeyMD5 = s.sseCustomerFields()
 
 	// Check if object exists
-	resp, err := s.s3.HeadObject(headInput)
+	resp, err := s.s3.HeadObjectWithContext(ctx, headInput)
 	if err == nil {
 		return nil, &os.PathError{Op: "create", Path: key, Err: os.ErrExist}
 	}
 	_ = resp
 
+	if len(s.ResumeDir) > 0 {
+		return s.createResumable(ctx, id, key)
+	}
+
+	// Build a per-call uploader rather than mutating the Store's shared one,
+	// so concurrent Create calls don't race over its PartSize/Concurrency/
+	// LeavePartsOnError fields.
+	uploader := s3manager.NewUploaderWithClient(s.s3, func(u *s3manager.Uploader) {
+		if s.PartSize > 0 {
+			u.PartSize = s.PartSize
+		}
+		if s.Concurrency > 0 {
+			u.Concurrency = s.Concurrency
+		}
+		u.LeavePartsOnError = s.LeavePartsOnError
+	})
+
 	r, w := io.Pipe()
+
 	out := &s3WriteCloser{
+		ctx,
 		s.ConfirmCreate, s.ConfirmationTimeout, s.ConfirmationRequestRate,
-		id, s.s3, w, false, headInput,
+		id, s.s3, s.bucket, key, w, s.VerifyOnWrite, nil, nil, false, headInput,
+	}
+
+	if s.VerifyOnWrite {
+		hr, hw := io.Pipe()
+		idc := make(chan c4.ID, 1)
+		out.hw = hw
+		out.idc = idc
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			idc <- c4.Identify(hr)
+		}()
 	}
 
 	s.wg.Add(1)
 	go func() {
 		defer s.wg.Done()
 
-		_, err := s.uploader.Upload(&s3manager.UploadInput{
+		uploadInput := &s3manager.UploadInput{
 			Bucket: aws.String(s.bucket),
 			Key:    aws.String(key),
 			Body:   r,
-		})
+		}
+		if len(s.SSEAlgorithm) > 0 {
+			uploadInput.ServerSideEncryption = aws.String(s.SSEAlgorithm)
+		}
+		if len(s.SSEKMSKeyID) > 0 {
+			uploadInput.SSEKMSKeyId = aws.String(s.SSEKMSKeyID)
+		}
+		uploadInput.SSECustomerAlgorithm, uploadInput.SSECustomerKey, uploadInput.SSECustomerKeyMD5 = s.sseCustomerFields()
+		if len(s.StorageClass) > 0 {
+			uploadInput.StorageClass = aws.String(s.StorageClass)
+		}
+		if len(s.ACL) > 0 {
+			uploadInput.ACL = aws.String(s.ACL)
+		}
+		uploadInput.Metadata = s.metadata()
+
+		_, err := uploader.UploadWithContext(ctx, uploadInput)
 		if err != nil {
 			r.CloseWithError(err)
 		}
@@ -221,13 +389,39 @@ func (s *Store) Create(id c4.ID) (io.WriteCloser, error) {
 	return out, nil
 }
 
+// Close waits for any in-flight Open/Create goroutines to finish. It is
+// equivalent to CloseContext(context.Background()).
 func (s *Store) Close() error {
-	s.wg.Wait()
-	return nil
+	return s.CloseContext(context.Background())
 }
 
+// CloseContext is the context-aware variant of Close. If ctx is canceled
+// before the background goroutines finish, CloseContext returns ctx.Err()
+// without waiting for them further.
+func (s *Store) CloseContext(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+		return nil
+	}
+}
+
+// Remove deletes the object for `id`. It is equivalent to
+// RemoveContext(context.Background(), id).
 func (s *Store) Remove(id c4.ID) error {
+	return s.RemoveContext(context.Background(), id)
+}
+
+// RemoveContext is the context-aware variant of Remove.
+func (s *Store) RemoveContext(ctx context.Context, id c4.ID) error {
 	path := filepath.Join(s.prefix, id.String())
-	_, err := s.s3.DeleteObject(&s3.DeleteObjectInput{Bucket: &s.bucket, Key: &path})
+	_, err := s.s3.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{Bucket: &s.bucket, Key: &path})
 	return err
 }
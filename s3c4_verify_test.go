@@ -0,0 +1,64 @@
+package s3c4
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+type nopWriteCloser struct {
+	*bytes.Buffer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// TestS3WriteCloserVerifyMismatch drives s3WriteCloser directly (no network)
+// to confirm Close deletes the just-uploaded object and returns
+// ErrIDMismatch when the streamed bytes don't hash to the requested id.
+func TestS3WriteCloserVerifyMismatch(t *testing.T) {
+	wantID := c4.Identify(bytes.NewReader([]byte("expected bytes")))
+
+	var deletedKey string
+	mock := &mockS3{
+		deleteObjectWithContext: func(in *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+			deletedKey = *in.Key
+			return &s3.DeleteObjectOutput{}, nil
+		},
+	}
+
+	hr, hw := io.Pipe()
+	idc := make(chan c4.ID, 1)
+	go func() { idc <- c4.Identify(hr) }()
+
+	w := &s3WriteCloser{
+		ctx:    context.Background(),
+		id:     wantID,
+		s3:     mock,
+		bucket: "bucket",
+		key:    "store/key",
+		w:      nopWriteCloser{&bytes.Buffer{}},
+		verify: true,
+		hw:     hw,
+		idc:    idc,
+	}
+
+	if _, err := w.Write([]byte("actual bytes")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	err := w.Close()
+	mismatch, ok := err.(ErrIDMismatch)
+	if !ok {
+		t.Fatalf("expected ErrIDMismatch, got %v (%T)", err, err)
+	}
+	if mismatch.Expected != wantID {
+		t.Fatalf("expected mismatch.Expected == %s, got %s", wantID, mismatch.Expected)
+	}
+	if deletedKey != "store/key" {
+		t.Fatalf("expected DeleteObject to be called with key %q, got %q", "store/key", deletedKey)
+	}
+}